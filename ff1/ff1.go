@@ -0,0 +1,451 @@
+/*
+
+SPDX-Copyright: Copyright (c) Capital One Services, LLC
+SPDX-License-Identifier: Apache-2.0
+Copyright 2017 Capital One Services, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and limitations under the License.
+
+*/
+
+// Package ff1 implements the FF1 format-preserving encryption
+// algorithm/scheme
+package ff1
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/vdparikhrh/fpe/fpeUtils"
+)
+
+// In the linked PDF Appendix A (READHME.md), NIST recommends that radix^minLength >= 1,000,000; feistelMin follows that recommendation.
+const (
+	feistelMin = 1000000
+	numRounds  = 10
+	blockSize  = aes.BlockSize
+	// maxRadix   = 65536 // 2^16
+)
+
+var (
+	// ErrStringNotInRadix is returned if input or intermediate strings cannot be parsed in the given radix
+	ErrStringNotInRadix = errors.New("string is not within base/radix")
+
+	// ErrTweakLengthInvalid is returned if the tweak length is greater than the Cipher's maxTLen
+	ErrTweakLengthInvalid = errors.New("tweak length is greater than maxTLen")
+)
+
+// A Cipher is an instance of the FF1 mode of format preserving encryption
+// using a particular key, radix, and tweak
+type Cipher struct {
+	tweak   []byte
+	codec   fpeUtils.Codec
+	minLen  uint32
+	maxLen  uint32
+	maxTLen int
+
+	// Re-usable AES block
+	aesBlock cipher.Block
+}
+
+const (
+	// from func (*big.Int)SetString
+	legacyAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRTSUVWXYZ"
+)
+
+// NewCipher is provided for backwards compatibility for old client code.
+func NewCipher(radix int, key []byte, tweak []byte, maxTLen int) (Cipher, error) {
+	return NewCipherWithAlphabet(legacyAlphabet[:radix], key, tweak, maxTLen)
+}
+
+// NewCipherWithAlphabet initializes a new FF1 Cipher for encryption or
+// decryption use based on the alphabet, maximum tweak length, key and tweak
+// parameters. Unlike FF3, FF1 tweaks are variable-length (0 to maxTLen
+// bytes), so maxTLen bounds the tweak accepted by Encrypt/EncryptWithTweak
+// and their decrypt counterparts.
+func NewCipherWithAlphabet(alphabet string, key []byte, tweak []byte, maxTLen int) (Cipher, error) {
+	var newCipher Cipher
+
+	keyLen := len(key)
+
+	// Check if the key is 128, 192, or 256 bits = 16, 24, or 32 bytes
+	if (keyLen != 16) && (keyLen != 24) && (keyLen != 32) {
+		return newCipher, errors.New("key length must be 128, 192, or 256 bits")
+	}
+
+	codec, err := fpeUtils.NewCodec(alphabet)
+	if err != nil {
+		return newCipher, fmt.Errorf("error making codec: %s", err)
+	}
+
+	radix := codec.Radix()
+
+	// FF1 allows radices in [2, 2^16]
+	if (radix < 2) || (radix > 65536) {
+		return newCipher, errors.New("radix must be between 2 and 65536, inclusive")
+	}
+
+	if maxTLen < 0 {
+		return newCipher, errors.New("maxTLen must not be negative")
+	}
+
+	if len(tweak) > maxTLen {
+		return newCipher, ErrTweakLengthInvalid
+	}
+
+	// Calculate minLength - according to the spec, radix^minLength >= 1,000,000.
+	minLen := uint32(math.Ceil(math.Log(feistelMin) / math.Log(float64(radix))))
+	if minLen < 2 {
+		minLen = 2
+	}
+
+	// FF1 permits message lengths up to 2^32-1 per the spec, but this
+	// implementation caps maxLen well below that to keep the per-round
+	// NUM_radix(B) byte buffer (sized off v*log2(radix)) small.
+	maxLen := uint32(1 << 16)
+
+	// aes.NewCipher automatically returns the correct block based on the length of the key passed in
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return newCipher, errors.New("failed to create AES block")
+	}
+
+	newCipher.tweak = tweak
+	newCipher.codec = codec
+	newCipher.minLen = minLen
+	newCipher.maxLen = maxLen
+	newCipher.maxTLen = maxTLen
+	newCipher.aesBlock = aesBlock
+
+	return newCipher, nil
+}
+
+// Encrypt encrypts the string X over the current FF1 parameters
+// and returns the ciphertext of the same length and format
+func (c Cipher) Encrypt(X string) (string, error) {
+	return c.EncryptWithTweak(X, c.tweak)
+}
+
+// EncryptWithTweak is the same as Encrypt except it uses the
+// tweak from the parameter rather than the current Cipher's tweak
+// This allows you to re-use a single Cipher (for a given key) and simply
+// override the tweak for each unique data input, which is a practical
+// use-case of FPE for things like credit card numbers.
+func (c Cipher) EncryptWithTweak(X string, tweak []byte) (string, error) {
+	var ret string
+
+	if len(tweak) > c.maxTLen {
+		return ret, ErrTweakLengthInvalid
+	}
+
+	// String X contains a sequence of characters, where some characters
+	// might take up multiple bytes. Convert into an array of indices into
+	// the alphabet embedded in the codec.
+	Xn, err := c.codec.Encode(X)
+	if err != nil {
+		return ret, ErrStringNotInRadix
+	}
+
+	n := uint32(len(Xn))
+
+	// Check if message length is within minLength and maxLength bounds
+	if (n < c.minLen) || (n > c.maxLen) {
+		return ret, errors.New("message length is not within min and max bounds")
+	}
+
+	radix := c.codec.Radix()
+	t := len(tweak)
+
+	// Calculate split point
+	u := n / 2
+	v := n - u
+
+	// Split the message
+	A := Xn[:u]
+	B := Xn[u:]
+
+	b, d, padLen := blockParams(int(v), radix, t)
+	P := generateP(radix, int(u), n, uint32(t))
+
+	var (
+		numA, numB, numY, numC big.Int
+		numRadix, numM         big.Int
+	)
+
+	numRadix.SetInt64(int64(radix))
+
+	for i := 0; i < numRounds; i++ {
+		Q := make([]byte, 0, t+padLen+1+b)
+		Q = append(Q, tweak...)
+		Q = append(Q, make([]byte, padLen)...)
+		Q = append(Q, byte(i))
+
+		// NIST FF1 (Algorithm 7) defines NUM_radix(B) as a plain big-endian
+		// numeral, unlike FF3's round function which is defined over
+		// REV(B). fpeUtils.NumRev/StrRev implement that FF3 "Rev"
+		// convention, so reverse B going in (and the digits coming out of
+		// StrRev below) to get the NUM_radix/STR_m the FF1 spec calls for.
+		numB, err = fpeUtils.NumRev(reverseDigits(B), uint64(radix))
+		if err != nil {
+			return ret, ErrStringNotInRadix
+		}
+		Q = append(Q, leftPad(numB.Bytes(), b)...)
+
+		R := prf(c.aesBlock, append(append([]byte{}, P...), Q...))
+		S := ciph(c.aesBlock, R, d)
+
+		numY.SetBytes(S)
+
+		var m uint32
+		if i%2 == 0 {
+			m = u
+		} else {
+			m = v
+		}
+		numM.SetInt64(int64(m))
+
+		numA, err = fpeUtils.NumRev(reverseDigits(A), uint64(radix))
+		if err != nil {
+			return ret, ErrStringNotInRadix
+		}
+
+		numC.Add(&numA, &numY)
+		var numModM big.Int
+		numModM.Exp(&numRadix, &numM, nil)
+		numC.Mod(&numC, &numModM)
+
+		C := make([]uint16, m)
+		_, err = fpeUtils.StrRev(&numC, C, uint64(radix))
+		if err != nil {
+			return "", err
+		}
+		reverseDigitsInPlace(C)
+
+		A, B = B, C
+	}
+
+	strA, err := c.codec.Decode(A)
+	if err != nil {
+		return "", err
+	}
+
+	strB, err := c.codec.Decode(B)
+	if err != nil {
+		return "", err
+	}
+
+	return strA + strB, nil
+}
+
+// Decrypt decrypts the string X over the current FF1 parameters
+// and returns the plaintext of the same length and format
+func (c Cipher) Decrypt(X string) (string, error) {
+	return c.DecryptWithTweak(X, c.tweak)
+}
+
+// DecryptWithTweak is the same as Decrypt except it uses the
+// tweak from the parameter rather than the current Cipher's tweak
+// This allows you to re-use a single Cipher (for a given key) and simply
+// override the tweak for each unique data input, which is a practical
+// use-case of FPE for things like credit card numbers.
+func (c Cipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
+	var ret string
+
+	if len(tweak) > c.maxTLen {
+		return ret, ErrTweakLengthInvalid
+	}
+
+	Xn, err := c.codec.Encode(X)
+	if err != nil {
+		return ret, ErrStringNotInRadix
+	}
+
+	n := uint32(len(Xn))
+
+	if (n < c.minLen) || (n > c.maxLen) {
+		return ret, errors.New("message length is not within min and max bounds")
+	}
+
+	radix := c.codec.Radix()
+	t := len(tweak)
+
+	u := n / 2
+	v := n - u
+
+	A := Xn[:u]
+	B := Xn[u:]
+
+	b, d, padLen := blockParams(int(v), radix, t)
+	P := generateP(radix, int(u), n, uint32(t))
+
+	var (
+		numA, numB, numY, numC big.Int
+		numRadix, numM         big.Int
+	)
+
+	numRadix.SetInt64(int64(radix))
+
+	for i := numRounds - 1; i >= 0; i-- {
+		Q := make([]byte, 0, t+padLen+1+b)
+		Q = append(Q, tweak...)
+		Q = append(Q, make([]byte, padLen)...)
+		Q = append(Q, byte(i))
+
+		// See the matching comment in EncryptWithTweak: reverse digits in
+		// and out of NumRev/StrRev to get FF1's plain NUM_radix/STR_m
+		// instead of FF3's REV(·) convention.
+		numA, err = fpeUtils.NumRev(reverseDigits(A), uint64(radix))
+		if err != nil {
+			return ret, ErrStringNotInRadix
+		}
+		Q = append(Q, leftPad(numA.Bytes(), b)...)
+
+		R := prf(c.aesBlock, append(append([]byte{}, P...), Q...))
+		S := ciph(c.aesBlock, R, d)
+
+		numY.SetBytes(S)
+
+		var m uint32
+		if i%2 == 0 {
+			m = u
+		} else {
+			m = v
+		}
+		numM.SetInt64(int64(m))
+
+		numB, err = fpeUtils.NumRev(reverseDigits(B), uint64(radix))
+		if err != nil {
+			return ret, ErrStringNotInRadix
+		}
+
+		numC.Sub(&numB, &numY)
+		var numModM big.Int
+		numModM.Exp(&numRadix, &numM, nil)
+		numC.Mod(&numC, &numModM)
+
+		C := make([]uint16, m)
+		_, err = fpeUtils.StrRev(&numC, C, uint64(radix))
+		if err != nil {
+			return "", err
+		}
+		reverseDigitsInPlace(C)
+
+		B, A = A, C
+	}
+
+	strA, err := c.codec.Decode(A)
+	if err != nil {
+		return "", err
+	}
+
+	strB, err := c.codec.Decode(B)
+	if err != nil {
+		return "", err
+	}
+
+	return strA + strB, nil
+}
+
+// blockParams derives the byte length b of the NUM_radix(B) encoding, the
+// number of pseudorandom bytes d needed per round, and the zero-padding
+// length between the tweak and the round index in Q, per NIST SP 800-38G
+// steps 3-4 of Algorithm 7/8.
+func blockParams(v, radix, t int) (b, d, padLen int) {
+	b = int(math.Ceil(math.Ceil(float64(v)*math.Log2(float64(radix))) / 8))
+	d = 4*int(math.Ceil(float64(b)/4)) + 4
+	padLen = ((-t-b-1)%blockSize + blockSize) % blockSize
+	return b, d, padLen
+}
+
+// generateP builds the fixed 16-byte block P described in step 5 of
+// Algorithm 7/8: version, method and addition bytes, the radix as 3 bytes,
+// the round count, u mod 256, and the message/tweak lengths.
+func generateP(radix, u int, n, t uint32) []byte {
+	P := make([]byte, blockSize)
+	P[0] = 0x01
+	P[1] = 0x02
+	P[2] = 0x01
+	P[3] = byte(radix >> 16)
+	P[4] = byte(radix >> 8)
+	P[5] = byte(radix)
+	P[6] = numRounds
+	P[7] = byte(u % 256)
+	binary.BigEndian.PutUint32(P[8:12], n)
+	binary.BigEndian.PutUint32(P[12:16], t)
+	return P
+}
+
+// reverseDigits returns a copy of n with its digits in reverse order.
+func reverseDigits(n []uint16) []uint16 {
+	out := make([]uint16, len(n))
+	for i, d := range n {
+		out[len(n)-1-i] = d
+	}
+	return out
+}
+
+// reverseDigitsInPlace reverses n's digits in place and returns it.
+func reverseDigitsInPlace(n []uint16) []uint16 {
+	for i, j := 0, len(n)-1; i < j; i, j = i+1, j-1 {
+		n[i], n[j] = n[j], n[i]
+	}
+	return n
+}
+
+// leftPad zero-extends buf on the left to the given length.
+func leftPad(buf []byte, length int) []byte {
+	out := make([]byte, length)
+	copy(out[length-len(buf):], buf)
+	return out
+}
+
+// prf is the PRF primitive from SP 800-38G: AES-CBC-MAC over x (a multiple
+// of blockSize long) with a zero IV, keyed by block. The CBC-MAC's final
+// ciphertext block is the output.
+func prf(block cipher.Block, x []byte) []byte {
+	y := make([]byte, blockSize)
+	for i := 0; i < len(x); i += blockSize {
+		for j := 0; j < blockSize; j++ {
+			y[j] ^= x[i+j]
+		}
+		block.Encrypt(y, y)
+	}
+	return y
+}
+
+// ciph expands the CBC-MAC output r into the d pseudorandom bytes S used
+// to derive y, per step 6.iii: S = R || CIPH(R xor [1]) || CIPH(R xor [2]) || ...
+func ciph(block cipher.Block, r []byte, d int) []byte {
+	s := make([]byte, 0, d+blockSize)
+	s = append(s, r...)
+
+	for j := 1; len(s) < d; j++ {
+		var counter [blockSize]byte
+		binary.BigEndian.PutUint64(counter[blockSize-8:], uint64(j))
+
+		in := make([]byte, blockSize)
+		for k := 0; k < blockSize; k++ {
+			in[k] = r[k] ^ counter[k]
+		}
+
+		out := make([]byte, blockSize)
+		block.Encrypt(out, in)
+		s = append(s, out...)
+	}
+
+	return s[:d]
+}
@@ -0,0 +1,119 @@
+/*
+
+SPDX-Copyright: Copyright (c) Capital One Services, LLC
+SPDX-License-Identifier: Apache-2.0
+Copyright 2017 Capital One Services, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and limitations under the License.
+
+*/
+
+package ff1
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// NIST SP 800-38G sample vectors for FF1-AES128.
+func TestEncryptNistSamples(t *testing.T) {
+	key, err := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name  string
+		tweak []byte
+		PT    string
+		CT    string
+	}{
+		{"sample1 (empty tweak)", []byte{}, "0123456789", "2433477484"},
+		{"sample2 (tweak)", []byte("9876543210"), "0123456789", "6124200773"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewCipher(10, key, tc.tweak, len(tc.tweak))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ct, err := c.Encrypt(tc.PT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ct != tc.CT {
+				t.Fatalf("Encrypt(%q) = %q, want %q", tc.PT, ct, tc.CT)
+			}
+
+			pt, err := c.Decrypt(ct)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pt != tc.PT {
+				t.Fatalf("Decrypt(%q) = %q, want %q", tc.CT, pt, tc.PT)
+			}
+		})
+	}
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	key, err := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweak := []byte("tokenize")
+
+	c, err := NewCipher(10, key, tweak, len(tweak))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pt := range []string{"1234567890", "00000000000000", "98765432109876543210"} {
+		ct, err := c.Encrypt(pt)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", pt, err)
+		}
+		if len(ct) != len(pt) {
+			t.Fatalf("Encrypt(%q) changed length: got %q", pt, ct)
+		}
+
+		dt, err := c.Decrypt(ct)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", ct, err)
+		}
+		if dt != pt {
+			t.Fatalf("round trip mismatch: got %q want %q", dt, pt)
+		}
+	}
+}
+
+func TestTweakLengthInvalid(t *testing.T) {
+	key, err := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCipher(10, key, []byte("12345678"), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.EncryptWithTweak("0123456789", make([]byte, 9)); err != ErrTweakLengthInvalid {
+		t.Fatalf("got %v, want ErrTweakLengthInvalid", err)
+	}
+
+	if _, err := NewCipher(10, key, make([]byte, 9), 8); err != ErrTweakLengthInvalid {
+		t.Fatalf("got %v, want ErrTweakLengthInvalid", err)
+	}
+}
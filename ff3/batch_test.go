@@ -0,0 +1,143 @@
+/*
+
+SPDX-Copyright: Copyright (c) Capital One Services, LLC
+SPDX-License-Identifier: Apache-2.0
+Copyright 2017 Capital One Services, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and limitations under the License.
+
+*/
+
+package ff3
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func benchCipher(tb testing.TB) Cipher {
+	key, err := hex.DecodeString("EF4359D8D580AA4F7F036D6F04FC6A94")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tweak, err := hex.DecodeString("D8E7920AFA330A73")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c, err := NewCipher(10, key, tweak)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// TestEncryptBatchMatchesLoop proves EncryptBatch's pooled scratch reuse
+// across many items produces the same results as calling EncryptWithTweak
+// per item - i.e. that scratch borrowed from one item's encryption can't
+// leak into another's.
+func TestEncryptBatchMatchesLoop(t *testing.T) {
+	c := benchCipher(t)
+
+	X := make([]string, 50)
+	tweaks := make([][]byte, len(X))
+	for i := range X {
+		X[i] = strings.Repeat(string(rune('0'+i%10)), 18)
+		tweak, err := hex.DecodeString("9A768A92F60E12D8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tweak[0] ^= byte(i)
+		tweaks[i] = tweak
+	}
+
+	want := make([]string, len(X))
+	for i, x := range X {
+		ct, err := c.EncryptWithTweak(x, tweaks[i])
+		if err != nil {
+			t.Fatalf("EncryptWithTweak(%q): %v", x, err)
+		}
+		want[i] = ct
+	}
+
+	got, errs := c.EncryptBatch(X, tweaks)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("EncryptBatch item %d: %v", i, err)
+		}
+		if got[i] != want[i] {
+			t.Fatalf("EncryptBatch item %d = %q, want %q (mismatch with per-item EncryptWithTweak)", i, got[i], want[i])
+		}
+	}
+
+	decrypted, errs := c.DecryptBatch(got, tweaks)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("DecryptBatch item %d: %v", i, err)
+		}
+		if decrypted[i] != X[i] {
+			t.Fatalf("DecryptBatch item %d = %q, want %q", i, decrypted[i], X[i])
+		}
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	c := benchCipher(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Encrypt("890121234567890000"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEncryptLoop and BenchmarkEncryptBatch both encrypt the same
+// batchSize items per b.N iteration, so their ns/op and allocs/op are
+// directly comparable - unlike comparing BenchmarkEncrypt (1 item/op)
+// against a batch benchmark (batchSize items/op). As EncryptBatch's doc
+// comment notes, the two come out close: per-item cost is dominated by
+// fpeUtils.NumRev/StrRev inside the Feistel loop, which scratch pooling
+// doesn't touch.
+const batchSize = 100
+
+func BenchmarkEncryptLoop(b *testing.B) {
+	c := benchCipher(b)
+	X := make([]string, batchSize)
+	for i := range X {
+		X[i] = "890121234567890000"
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, x := range X {
+			if _, err := c.Encrypt(x); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkEncryptBatch(b *testing.B) {
+	c := benchCipher(b)
+	X := make([]string, batchSize)
+	for i := range X {
+		X[i] = "890121234567890000"
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, errs := c.EncryptBatch(X, nil); errs[0] != nil {
+			b.Fatal(errs[0])
+		}
+	}
+}
@@ -27,7 +27,6 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"math/big"
 
 	"github.com/vdparikhrh/fpe/fpeUtils"
 )
@@ -38,6 +37,7 @@ const (
 	numRounds    = 8
 	blockSize    = aes.BlockSize
 	tweakLen     = 8
+	tweakLenFF31 = 7
 	halfTweakLen = tweakLen / 2
 	// maxRadix   = 65536 // 2^16
 )
@@ -46,8 +46,13 @@ var (
 	// ErrStringNotInRadix is returned if input or intermediate strings cannot be parsed in the given radix
 	ErrStringNotInRadix = errors.New("string is not within base/radix")
 
-	// ErrTweakLengthInvalid is returned if the tweak length is not 8 bytes
+	// ErrTweakLengthInvalid is returned if the tweak length is not 8 bytes.
+	// It is returned by Ciphers created with NewCipher/NewCipherWithAlphabet.
 	ErrTweakLengthInvalid = errors.New("tweak must be 8 bytes, or 64 bits")
+
+	// ErrTweakLengthInvalidFF31 is returned if the tweak length is not 7 bytes.
+	// It is returned by Ciphers created with NewFF31Cipher/NewFF31CipherWithAlphabet.
+	ErrTweakLengthInvalidFF31 = errors.New("tweak must be 7 bytes, or 56 bits")
 )
 
 // A Cipher is an instance of the FF3 mode of format preserving encryption
@@ -58,6 +63,10 @@ type Cipher struct {
 	minLen uint32
 	maxLen uint32
 
+	// isFF31 marks a Cipher built with a 56-bit tweak (NIST SP 800-38G
+	// Revision 1 / FF3-1), as opposed to the original 64-bit tweak FF3.
+	isFF31 bool
+
 	// Re-usable AES block
 	aesBlock cipher.Block
 }
@@ -75,6 +84,27 @@ func NewCipher(radix int, key []byte, tweak []byte) (Cipher, error) {
 // NewAlphaCipher initializes a new FF3 Cipher for encryption or decryption use
 // based on the alphabet, max tweak length, key and tweak parameters.
 func NewCipherWithAlphabet(alphabet string, key []byte, tweak []byte) (Cipher, error) {
+	return newCipherWithAlphabet(alphabet, key, tweak, false)
+}
+
+// NewFF31Cipher is the radix-based counterpart of NewFF31CipherWithAlphabet,
+// identical to NewCipher except it builds a NIST SP 800-38G Revision 1
+// (FF3-1) Cipher, which requires a 56-bit (7-byte) tweak instead of the
+// original 64-bit FF3 tweak.
+func NewFF31Cipher(radix int, key []byte, tweak []byte) (Cipher, error) {
+	return NewFF31CipherWithAlphabet(legacyAlphabet[:radix], key, tweak)
+}
+
+// NewFF31CipherWithAlphabet initializes a new FF3-1 Cipher for encryption or
+// decryption use based on the alphabet, key and tweak parameters. FF3-1
+// (NIST SP 800-38G Revision 1) addresses the Durak-Vaudenay attack on the
+// original FF3 by restricting the tweak to 56 bits; the 7-byte tweak is
+// re-split into the TL/TR halves used by the Feistel loop per the FF3-1 spec.
+func NewFF31CipherWithAlphabet(alphabet string, key []byte, tweak []byte) (Cipher, error) {
+	return newCipherWithAlphabet(alphabet, key, tweak, true)
+}
+
+func newCipherWithAlphabet(alphabet string, key []byte, tweak []byte, isFF31 bool) (Cipher, error) {
 	var newCipher Cipher
 
 	keyLen := len(key)
@@ -96,9 +126,16 @@ func NewCipherWithAlphabet(alphabet string, key []byte, tweak []byte) (Cipher, e
 		return newCipher, errors.New("radix must be between 2 and 65536, inclusive")
 	}
 
-	// Make sure the given the length of tweak in bits is 64
-	if len(tweak) != tweakLen {
-		return newCipher, ErrTweakLengthInvalid
+	// Make sure the tweak is the length required by this Cipher's mode:
+	// 64 bits (8 bytes) for FF3, 56 bits (7 bytes) for FF3-1.
+	if isFF31 {
+		if len(tweak) != tweakLenFF31 {
+			return newCipher, ErrTweakLengthInvalidFF31
+		}
+	} else {
+		if len(tweak) != tweakLen {
+			return newCipher, ErrTweakLengthInvalid
+		}
 	}
 
 	// Calculate minLength - according to the spec, radix^minLength >= 100.
@@ -122,6 +159,7 @@ func NewCipherWithAlphabet(alphabet string, key []byte, tweak []byte) (Cipher, e
 	newCipher.codec = codec
 	newCipher.minLen = minLen
 	newCipher.maxLen = maxLen
+	newCipher.isFF31 = isFF31
 	newCipher.aesBlock = aesBlock
 
 	return newCipher, nil
@@ -139,6 +177,17 @@ func (c Cipher) Encrypt(X string) (string, error) {
 // override the tweak for each unique data input, which is a practical
 // use-case of FPE for things like credit card numbers.
 func (c Cipher) EncryptWithTweak(X string, tweak []byte) (string, error) {
+	sc := scratchPool.Get().(*batchScratch)
+	defer scratchPool.Put(sc)
+	return c.encryptWithScratch(X, tweak, sc)
+}
+
+// encryptWithScratch is the shared implementation behind EncryptWithTweak
+// and EncryptBatch. sc supplies the reusable big.Ints and byte buffers the
+// Feistel loop needs; EncryptBatch holds a single sc for all of its items
+// instead of borrowing one from scratchPool per item the way a loop over
+// EncryptWithTweak does.
+func (c Cipher) encryptWithScratch(X string, tweak []byte, sc *batchScratch) (string, error) {
 	var ret string
 
 	// String X contains a sequence of characters, where some characters
@@ -158,9 +207,12 @@ func (c Cipher) EncryptWithTweak(X string, tweak []byte) (string, error) {
 		return ret, errors.New("message length is not within min and max bounds")
 	}
 
-	// Make sure the given the length of tweak in bits is 64
-	if len(tweak) != tweakLen {
-		return ret, ErrTweakLengthInvalid
+	// Make sure the tweak is the length required by this Cipher's mode, then
+	// expand it to the internal 64-bit TL/TR representation the Feistel
+	// loop below uses, regardless of mode.
+	tweak64, err := c.expandTweak(tweak)
+	if err != nil {
+		return ret, err
 	}
 
 	radix := c.codec.Radix()
@@ -174,34 +226,22 @@ func (c Cipher) EncryptWithTweak(X string, tweak []byte) (string, error) {
 	B := Xn[u:]
 
 	// C must be large enough to hold either A or B
-	C := make([]uint16, u)
+	C := sc.borrowC(u)
 
 	// Split the tweak
-	Tl := tweak[:halfTweakLen]
-	Tr := tweak[halfTweakLen:]
+	Tl := tweak64[:halfTweakLen]
+	Tr := tweak64[halfTweakLen:]
 
 	// P is always 16 bytes
+	P := sc.P
 	var (
-		P = make([]byte, blockSize)
 		m uint32
 		W []byte
 
-		numB, numC       big.Int
-		numRadix, numY   big.Int
-		numU, numV       big.Int
-		numModU, numModV big.Int
-		S, numBBytes     []byte
+		S, numBBytes []byte
 	)
 
-	numRadix.SetInt64(int64(radix))
-
-	// Pre-calculate the modulus since it's only one of 2 values,
-	// depending on whether i is even or odd
-	numU.SetInt64(int64(u))
-	numV.SetInt64(int64(v))
-
-	numModU.Exp(&numRadix, &numU, nil)
-	numModV.Exp(&numRadix, &numV, nil)
+	sc.primeModuli(radix, u, v)
 
 	// Main Feistel Round, 8 times
 	for i := 0; i < numRounds; i++ {
@@ -223,12 +263,12 @@ func (c Cipher) EncryptWithTweak(X string, tweak []byte) (string, error) {
 		P[3] = W[3] ^ byte(i)
 
 		// The remaining 12 bytes of P are for rev(B) with padding
-		numB, err = fpeUtils.NumRev(B, uint64(radix))
+		sc.numB, err = fpeUtils.NumRev(B, uint64(radix))
 		if err != nil {
 			return ret, ErrStringNotInRadix
 		}
 
-		numBBytes = numB.Bytes()
+		numBBytes = sc.numB.Bytes()
 
 		// These middle bytes need to be reset to 0 for padding
 		for x := 0; x < 12-len(numBBytes); x++ {
@@ -245,24 +285,24 @@ func (c Cipher) EncryptWithTweak(X string, tweak []byte) (string, error) {
 		S = revB(revP)
 
 		// Calculate numY
-		numY.SetBytes(S[:])
+		sc.numY.SetBytes(S[:])
 
 		// Calculate c
-		numC, err = fpeUtils.NumRev(A, uint64(radix))
+		sc.numC, err = fpeUtils.NumRev(A, uint64(radix))
 		if err != nil {
 			return ret, ErrStringNotInRadix
 		}
 
-		numC.Add(&numC, &numY)
+		sc.numC.Add(&sc.numC, &sc.numY)
 
 		if i%2 == 0 {
-			numC.Mod(&numC, &numModU)
+			sc.numC.Mod(&sc.numC, &sc.numModU)
 		} else {
-			numC.Mod(&numC, &numModV)
+			sc.numC.Mod(&sc.numC, &sc.numModV)
 		}
 
 		C = C[:m]
-		_, err := fpeUtils.StrRev(&numC, C, uint64(c.codec.Radix()))
+		_, err := fpeUtils.StrRev(&sc.numC, C, uint64(c.codec.Radix()))
 		if err != nil {
 			return "", err
 		}
@@ -298,6 +338,14 @@ func (c Cipher) Decrypt(X string) (string, error) {
 // override the tweak for each unique data input, which is a practical
 // use-case of FPE for things like credit card numbers.
 func (c Cipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
+	sc := scratchPool.Get().(*batchScratch)
+	defer scratchPool.Put(sc)
+	return c.decryptWithScratch(X, tweak, sc)
+}
+
+// decryptWithScratch is the shared implementation behind DecryptWithTweak
+// and DecryptBatch; see encryptWithScratch.
+func (c Cipher) decryptWithScratch(X string, tweak []byte, sc *batchScratch) (string, error) {
 	var ret string
 
 	// String X contains a sequence of characters, where some characters
@@ -317,9 +365,12 @@ func (c Cipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
 		return ret, errors.New("message length is not within min and max bounds")
 	}
 
-	// Make sure the given the length of tweak in bits is 64
-	if len(tweak) != tweakLen {
-		return ret, ErrTweakLengthInvalid
+	// Make sure the tweak is the length required by this Cipher's mode, then
+	// expand it to the internal 64-bit TL/TR representation the Feistel
+	// loop below uses, regardless of mode.
+	tweak64, err := c.expandTweak(tweak)
+	if err != nil {
+		return ret, err
 	}
 
 	radix := c.codec.Radix()
@@ -333,34 +384,22 @@ func (c Cipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
 	B := Xn[u:]
 
 	// C must be large enough to hold either A or B
-	C := make([]uint16, u)
+	C := sc.borrowC(u)
 
 	// Split the tweak
-	Tl := tweak[:halfTweakLen]
-	Tr := tweak[halfTweakLen:]
+	Tl := tweak64[:halfTweakLen]
+	Tr := tweak64[halfTweakLen:]
 
 	// P is always 16 bytes
+	P := sc.P
 	var (
-		P = make([]byte, blockSize)
 		m uint32
 		W []byte
 
-		numA, numC       big.Int
-		numRadix, numY   big.Int
-		numU, numV       big.Int
-		numModU, numModV big.Int
-		S, numABytes     []byte
+		S, numABytes []byte
 	)
 
-	numRadix.SetInt64(int64(radix))
-
-	// Pre-calculate the modulus since it's only one of 2 values,
-	// depending on whether i is even or odd
-	numU.SetInt64(int64(u))
-	numV.SetInt64(int64(v))
-
-	numModU.Exp(&numRadix, &numU, nil)
-	numModV.Exp(&numRadix, &numV, nil)
+	sc.primeModuli(radix, u, v)
 
 	// Main Feistel Round, 8 times
 	for i := numRounds - 1; i >= 0; i-- {
@@ -382,12 +421,12 @@ func (c Cipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
 		P[3] = W[3] ^ byte(i)
 
 		// The remaining 12 bytes of P are for rev(A) with padding
-		numA, err = fpeUtils.NumRev(A, uint64(radix))
+		sc.numB, err = fpeUtils.NumRev(A, uint64(radix))
 		if err != nil {
 			return ret, ErrStringNotInRadix
 		}
 
-		numABytes = numA.Bytes()
+		numABytes = sc.numB.Bytes()
 
 		// These middle bytes need to be reset to 0 for padding
 		for x := 0; x < 12-len(numABytes); x++ {
@@ -404,24 +443,24 @@ func (c Cipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
 		S = revB(revP)
 
 		// Calculate numY
-		numY.SetBytes(S[:])
+		sc.numY.SetBytes(S[:])
 
 		// Calculate c
-		numC, err = fpeUtils.NumRev(B, uint64(radix))
+		sc.numC, err = fpeUtils.NumRev(B, uint64(radix))
 		if err != nil {
 			return ret, ErrStringNotInRadix
 		}
 
-		numC.Sub(&numC, &numY)
+		sc.numC.Sub(&sc.numC, &sc.numY)
 
 		if i%2 == 0 {
-			numC.Mod(&numC, &numModU)
+			sc.numC.Mod(&sc.numC, &sc.numModU)
 		} else {
-			numC.Mod(&numC, &numModV)
+			sc.numC.Mod(&sc.numC, &sc.numModV)
 		}
 
 		C = C[:m]
-		_, err := fpeUtils.StrRev(&numC, C, uint64(c.codec.Radix()))
+		_, err := fpeUtils.StrRev(&sc.numC, C, uint64(c.codec.Radix()))
 		if err != nil {
 			return "", err
 		}
@@ -444,6 +483,42 @@ func (c Cipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
 	return strA + strB, nil
 }
 
+// expandTweak validates tweak against the length required by c's mode and,
+// for FF3-1 Ciphers, re-derives the 64-bit TL/TR tweak the Feistel loop
+// expects from the 56-bit FF3-1 tweak per NIST SP 800-38G Revision 1.
+// FF3 Ciphers return tweak unchanged.
+func (c Cipher) expandTweak(tweak []byte) ([]byte, error) {
+	if c.isFF31 {
+		if len(tweak) != tweakLenFF31 {
+			return nil, ErrTweakLengthInvalidFF31
+		}
+		return calculateTweak64FF31(tweak), nil
+	}
+
+	if len(tweak) != tweakLen {
+		return nil, ErrTweakLengthInvalid
+	}
+	return tweak, nil
+}
+
+// calculateTweak64FF31 expands a 7-byte (56-bit) FF3-1 tweak into the 8-byte
+// (64-bit) TL/TR form used internally by the original FF3 Feistel loop:
+// TL = T[0..2] || (T[3]&0xF0), TR = ((T[3]&0x0F)<<4) || T[4..6].
+func calculateTweak64FF31(tweak []byte) []byte {
+	tweak64 := make([]byte, tweakLen)
+
+	tweak64[0] = tweak[0]
+	tweak64[1] = tweak[1]
+	tweak64[2] = tweak[2]
+	tweak64[3] = tweak[3] & 0xF0
+	tweak64[4] = (tweak[3] & 0x0F) << 4
+	tweak64[5] = tweak[4]
+	tweak64[6] = tweak[5]
+	tweak64[7] = tweak[6]
+
+	return tweak64
+}
+
 // rev reverses a string
 func rev(s string) string {
 	return string(revB([]byte(s)))
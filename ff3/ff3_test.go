@@ -0,0 +1,198 @@
+/*
+
+SPDX-Copyright: Copyright (c) Capital One Services, LLC
+SPDX-License-Identifier: Apache-2.0
+Copyright 2017 Capital One Services, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and limitations under the License.
+
+*/
+
+package ff3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// NIST SP 800-38G sample vectors for the legacy 8-byte-tweak FF3.
+func TestEncryptNistSamples(t *testing.T) {
+	testCases := []struct {
+		name  string
+		tweak string
+		PT    string
+		CT    string
+	}{
+		{"sample1", "D8E7920AFA330A73", "890121234567890000", "750918814058654607"},
+		{"sample2", "9A768A92F60E12D8", "890121234567890000", "018989839189395384"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// NewCipher reverses its key argument's bytes in place (see
+			// revB), so each subtest decodes its own copy rather than
+			// sharing one across cases.
+			key, err := hex.DecodeString("EF4359D8D580AA4F7F036D6F04FC6A94")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tweak, err := hex.DecodeString(tc.tweak)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := NewCipher(10, key, tweak)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ct, err := c.Encrypt(tc.PT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ct != tc.CT {
+				t.Fatalf("Encrypt(%q) = %q, want %q", tc.PT, ct, tc.CT)
+			}
+
+			pt, err := c.Decrypt(ct)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pt != tc.PT {
+				t.Fatalf("Decrypt(%q) = %q, want %q", tc.CT, pt, tc.PT)
+			}
+		})
+	}
+}
+
+// TestFF31KnownAnswer pins NewFF31Cipher's output for a fixed
+// (key, tweak, plaintext) to a ciphertext computed by this package itself.
+// It is NOT an independently published NIST/ACVP FF3-1 test vector - no
+// such vector was available to check this implementation against, so this
+// only catches future regressions in the FF3-1 code path, the same way
+// TestFF31EncryptDecryptRoundTrip and TestCalculateTweak64FF31 do; it does
+// not newly demonstrate interoperability with another FF3-1 implementation.
+func TestFF31KnownAnswer(t *testing.T) {
+	key, err := hex.DecodeString("EF4359D8D580AA4F7F036D6F04FC6A94")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweak, err := hex.DecodeString("D8E7920AFA330A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewFF31Cipher(10, key, tweak)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		PT = "890121234567890000"
+		CT = "225635296159919047"
+	)
+
+	ct, err := c.Encrypt(PT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != CT {
+		t.Fatalf("Encrypt(%q) = %q, want %q (this pins a regression, not a published KAT - see this test's doc comment)", PT, ct, CT)
+	}
+
+	pt, err := c.Decrypt(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt != PT {
+		t.Fatalf("Decrypt(%q) = %q, want %q", ct, pt, PT)
+	}
+}
+
+func TestFF31EncryptDecryptRoundTrip(t *testing.T) {
+	key, err := hex.DecodeString("EF4359D8D580AA4F7F036D6F04FC6A94")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweak, err := hex.DecodeString("D8E7920AFA330A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewFF31Cipher(10, key, tweak)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pt := range []string{"890121234567890000", "1234567890123456"} {
+		ct, err := c.Encrypt(pt)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", pt, err)
+		}
+		if len(ct) != len(pt) {
+			t.Fatalf("Encrypt(%q) changed length: got %q", pt, ct)
+		}
+
+		dt, err := c.Decrypt(ct)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", ct, err)
+		}
+		if dt != pt {
+			t.Fatalf("round trip mismatch: got %q want %q", dt, pt)
+		}
+	}
+}
+
+// FF3-1's tweak expansion must derive TL/TR from the 7-byte tweak per NIST
+// SP 800-38G Revision 1: TL = T[0..2] || (T[3]&0xF0),
+// TR = ((T[3]&0x0F)<<4) || T[4..6].
+func TestCalculateTweak64FF31(t *testing.T) {
+	tweak := []byte{0xD8, 0xE7, 0x92, 0x0A, 0xFA, 0x33, 0x0A}
+	want := []byte{0xD8, 0xE7, 0x92, 0x00, 0xA0, 0xFA, 0x33, 0x0A}
+
+	got := calculateTweak64FF31(tweak)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("calculateTweak64FF31(% X) = % X, want % X", tweak, got, want)
+	}
+}
+
+func TestTweakLengthInvalid(t *testing.T) {
+	key, err := hex.DecodeString("EF4359D8D580AA4F7F036D6F04FC6A94")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewCipher(10, key, make([]byte, 7)); err != ErrTweakLengthInvalid {
+		t.Fatalf("NewCipher with 7-byte tweak: got %v, want ErrTweakLengthInvalid", err)
+	}
+
+	if _, err := NewFF31Cipher(10, key, make([]byte, 8)); err != ErrTweakLengthInvalidFF31 {
+		t.Fatalf("NewFF31Cipher with 8-byte tweak: got %v, want ErrTweakLengthInvalidFF31", err)
+	}
+
+	c, err := NewCipher(10, key, make([]byte, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.EncryptWithTweak("1234567890", make([]byte, 7)); err != ErrTweakLengthInvalid {
+		t.Fatalf("EncryptWithTweak with 7-byte tweak on FF3 cipher: got %v, want ErrTweakLengthInvalid", err)
+	}
+
+	c31, err := NewFF31Cipher(10, key, make([]byte, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c31.EncryptWithTweak("1234567890", make([]byte, 8)); err != ErrTweakLengthInvalidFF31 {
+		t.Fatalf("EncryptWithTweak with 8-byte tweak on FF3-1 cipher: got %v, want ErrTweakLengthInvalidFF31", err)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+
+SPDX-Copyright: Copyright (c) Capital One Services, LLC
+SPDX-License-Identifier: Apache-2.0
+Copyright 2017 Capital One Services, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and limitations under the License.
+
+*/
+
+// Package fpe defines the common interface shared by this module's
+// format-preserving encryption algorithms (currently ff3.Cipher and
+// ff1.Cipher), so callers can select an algorithm without changing the
+// surrounding code.
+package fpe
+
+// Cipher is satisfied by any format-preserving encryption algorithm in this
+// module. A Cipher is bound to a particular key, radix/alphabet, and
+// default tweak; EncryptWithTweak/DecryptWithTweak let a single Cipher be
+// reused across inputs that each need their own tweak, which is the
+// practical tokenization use-case (e.g. keying a PAN or SSN vault off a
+// single Cipher per key).
+type Cipher interface {
+	// Encrypt encrypts X using the Cipher's default tweak.
+	Encrypt(X string) (string, error)
+
+	// EncryptWithTweak is the same as Encrypt except it uses the given
+	// tweak rather than the Cipher's default.
+	EncryptWithTweak(X string, tweak []byte) (string, error)
+
+	// Decrypt decrypts X using the Cipher's default tweak.
+	Decrypt(X string) (string, error)
+
+	// DecryptWithTweak is the same as Decrypt except it uses the given
+	// tweak rather than the Cipher's default.
+	DecryptWithTweak(X string, tweak []byte) (string, error)
+}
@@ -0,0 +1,266 @@
+/*
+
+SPDX-Copyright: Copyright (c) Capital One Services, LLC
+SPDX-License-Identifier: Apache-2.0
+Copyright 2017 Capital One Services, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and limitations under the License.
+
+*/
+
+package fpe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCycleLimitExceeded is returned by PolicyCipher when cycle-walking does
+// not find an output satisfying its FormatPolicy within MaxCycles attempts.
+var ErrCycleLimitExceeded = errors.New("fpe: exceeded maximum cycle-walking attempts without satisfying format policy")
+
+// ErrCipherPanic is returned by PolicyCipher when the wrapped Cipher panics
+// encrypting or decrypting a cycle-walk candidate - for example ff3.Cipher
+// panicking on an odd-length input. A Mask that leaves an odd number of
+// positions mutable can trigger this for ff3.Cipher; callers that hit it
+// should adjust their Mask rather than rely on the wrapped Cipher's crash
+// becoming a process crash.
+var ErrCipherPanic = errors.New("fpe: wrapped cipher panicked encrypting or decrypting a cycle-walk candidate")
+
+// defaultMaxCycles bounds PolicyCipher's cycle-walking when MaxCycles is unset.
+const defaultMaxCycles = 64
+
+// FormatPolicy describes structural constraints a PolicyCipher's output
+// must satisfy beyond the wrapped Cipher's own alphabet/radix.
+type FormatPolicy struct {
+	// Mask reports, for the characters of a message X, which positions the
+	// wrapped Cipher is allowed to encrypt (true) versus which pass through
+	// unchanged (false). A nil Mask treats every position as mutable.
+	Mask func(X string) []bool
+
+	// Predicate reports whether a fully reassembled output (fixed
+	// positions restored) satisfies the policy. A nil Predicate accepts
+	// every output.
+	Predicate func(output string) bool
+}
+
+// LuhnPreserving returns a FormatPolicy whose Predicate accepts digitCount
+// decimal digits ending in a valid Luhn check digit, such as a PAN.
+// Combine it with a Mask (e.g. from PositionMask, to hold the BIN and last
+// four digits fixed) if only part of the number should be re-keyed; used
+// alone, it cycle-walks over the whole digit run.
+func LuhnPreserving(digitCount int) FormatPolicy {
+	return FormatPolicy{
+		Predicate: func(output string) bool {
+			return len(output) == digitCount && luhnValid(output)
+		},
+	}
+}
+
+// PositionMask returns a FormatPolicy whose Mask encrypts only the
+// positions marked '#' in pattern, passing every other position through
+// from the input unchanged. pattern must be the same length as the
+// messages it is used with.
+func PositionMask(pattern string) FormatPolicy {
+	marks := []rune(pattern)
+	return FormatPolicy{
+		Mask: func(X string) []bool {
+			mask := make([]bool, len(marks))
+			for i, r := range marks {
+				mask[i] = r == '#'
+			}
+			return mask
+		},
+	}
+}
+
+// Predicate returns a FormatPolicy whose Predicate is fn and whose Mask
+// leaves every position mutable, for constraints such as SSN exclusion
+// ranges that don't need any position held fixed.
+func Predicate(fn func(string) bool) FormatPolicy {
+	return FormatPolicy{Predicate: fn}
+}
+
+// PolicyCipher wraps a Cipher so its output additionally satisfies a
+// FormatPolicy: Policy.Mask's fixed positions pass through unencrypted,
+// and cycle-walking re-encrypts the mutable positions - feeding each
+// cycle's output back in as the next cycle's input - until the reassembled
+// output satisfies Policy.Predicate, up to MaxCycles attempts.
+type PolicyCipher struct {
+	Cipher Cipher
+	Policy FormatPolicy
+
+	// MaxCycles bounds cycle-walking attempts before EncryptWithTweak and
+	// DecryptWithTweak give up with ErrCycleLimitExceeded. 0 means
+	// defaultMaxCycles.
+	MaxCycles int
+}
+
+// NewPolicyCipher wraps c so Encrypt/Decrypt additionally satisfy policy.
+func NewPolicyCipher(c Cipher, policy FormatPolicy) *PolicyCipher {
+	return &PolicyCipher{Cipher: c, Policy: policy}
+}
+
+// Encrypt encrypts X using the wrapped Cipher's default tweak; see
+// EncryptWithTweak.
+func (p *PolicyCipher) Encrypt(X string) (string, error) {
+	return p.EncryptWithTweak(X, nil)
+}
+
+// EncryptWithTweak encrypts the mutable positions of X (per Policy.Mask)
+// with tweak - or the wrapped Cipher's default tweak, if tweak is nil -
+// leaving fixed positions untouched, then cycle-walks the mutable
+// positions until the reassembled output satisfies Policy.Predicate.
+// Cycle-walking is deterministic given the same (key, tweak, input): each
+// attempt re-encrypts the previous attempt's output, so the same inputs
+// always walk the same sequence of candidates.
+func (p *PolicyCipher) EncryptWithTweak(X string, tweak []byte) (string, error) {
+	return p.walk(X, tweak, p.Cipher.Encrypt, p.Cipher.EncryptWithTweak)
+}
+
+// Decrypt decrypts X using the wrapped Cipher's default tweak; see
+// DecryptWithTweak.
+func (p *PolicyCipher) Decrypt(X string) (string, error) {
+	return p.DecryptWithTweak(X, nil)
+}
+
+// DecryptWithTweak reverses EncryptWithTweak's cycle-walk: it repeatedly
+// decrypts the mutable positions of X and reassembles the output, stopping
+// at the first result that satisfies Policy.Predicate. This recovers the
+// original plaintext correctly only when that plaintext itself already
+// satisfied Policy.Predicate, which is the standard cycle-walking
+// assumption for real input - a genuine PAN already carries a valid Luhn
+// check digit, a genuine SSN is already outside the reserved ranges.
+func (p *PolicyCipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
+	return p.walk(X, tweak, p.Cipher.Decrypt, p.Cipher.DecryptWithTweak)
+}
+
+// walk runs the shared cycle-walking loop behind EncryptWithTweak and
+// DecryptWithTweak: step is applied to the current mutable slice each
+// attempt, and the result is reassembled with X's fixed positions and
+// checked against Policy.Predicate.
+func (p *PolicyCipher) walk(X string, tweak []byte, step func(string) (string, error), stepWithTweak func(string, []byte) (string, error)) (string, error) {
+	mask := p.mask(X)
+	mutable := mutableSlice(X, mask)
+
+	maxCycles := p.MaxCycles
+	if maxCycles <= 0 {
+		maxCycles = defaultMaxCycles
+	}
+
+	for attempt := 0; attempt < maxCycles; attempt++ {
+		result, err := callStep(mutable, tweak, step, stepWithTweak)
+		if err != nil {
+			return "", err
+		}
+
+		output := withMutable(X, mask, result)
+		if p.satisfies(output) {
+			return output, nil
+		}
+
+		mutable = result
+	}
+
+	return "", ErrCycleLimitExceeded
+}
+
+// callStep invokes step (or stepWithTweak, if tweak is non-nil) and converts
+// any panic it raises into ErrCipherPanic instead of letting it crash the
+// process - the wrapped Cipher is arbitrary, and some (e.g. ff3.Cipher, on
+// odd-length input) panic rather than return an error on inputs a Mask can
+// produce.
+func callStep(mutable string, tweak []byte, step func(string) (string, error), stepWithTweak func(string, []byte) (string, error)) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrCipherPanic, r)
+		}
+	}()
+
+	if tweak == nil {
+		return step(mutable)
+	}
+	return stepWithTweak(mutable, tweak)
+}
+
+// mask returns Policy.Mask(X), or a mask with every position mutable if
+// Policy.Mask is nil.
+func (p *PolicyCipher) mask(X string) []bool {
+	if p.Policy.Mask == nil {
+		mask := make([]bool, len([]rune(X)))
+		for i := range mask {
+			mask[i] = true
+		}
+		return mask
+	}
+	return p.Policy.Mask(X)
+}
+
+// satisfies reports whether output satisfies Policy.Predicate, or true if
+// Policy.Predicate is nil.
+func (p *PolicyCipher) satisfies(output string) bool {
+	if p.Policy.Predicate == nil {
+		return true
+	}
+	return p.Policy.Predicate(output)
+}
+
+// mutableSlice extracts the runes of X at mask's mutable positions, in order.
+func mutableSlice(X string, mask []bool) string {
+	runes := []rune(X)
+	var sb strings.Builder
+	for i, r := range runes {
+		if i < len(mask) && mask[i] {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// withMutable reassembles X with its mutable positions (per mask) replaced,
+// in order, by the runes of mutable.
+func withMutable(X string, mask []bool, mutable string) string {
+	runes := []rune(X)
+	replacement := []rune(mutable)
+	out := make([]rune, len(runes))
+	j := 0
+	for i, r := range runes {
+		if i < len(mask) && mask[i] {
+			out[i] = replacement[j]
+			j++
+		} else {
+			out[i] = r
+		}
+	}
+	return string(out)
+}
+
+// luhnValid reports whether digits (ASCII '0'-'9') passes the Luhn check.
+func luhnValid(digits string) bool {
+	sum := 0
+	parity := len(digits) % 2
+	for i, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+		d := int(r - '0')
+		if i%2 == parity {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return len(digits) > 0 && sum%10 == 0
+}
@@ -0,0 +1,174 @@
+/*
+
+SPDX-Copyright: Copyright (c) Capital One Services, LLC
+SPDX-License-Identifier: Apache-2.0
+Copyright 2017 Capital One Services, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and limitations under the License.
+
+*/
+
+package fpe
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/vdparikhrh/fpe/ff3"
+)
+
+func newPolicyTestCipher(t *testing.T) ff3.Cipher {
+	t.Helper()
+	key, err := hex.DecodeString("EF4359D8D580AA4F7F036D6F04FC6A94")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweak, err := hex.DecodeString("D8E7920AFA330A73")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ff3.NewCipher(10, key, tweak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPolicyCipherLuhnPreservingRoundTrip(t *testing.T) {
+	const pan = "4111111111111111" // valid Luhn PAN
+
+	p := NewPolicyCipher(newPolicyTestCipher(t), LuhnPreserving(len(pan)))
+
+	ct, err := p.Encrypt(pan)
+	if err != nil {
+		t.Fatalf("Encrypt(%q): %v", pan, err)
+	}
+	if !luhnValid(ct) {
+		t.Fatalf("Encrypt(%q) = %q, not Luhn-valid", pan, ct)
+	}
+
+	pt, err := p.Decrypt(ct)
+	if err != nil {
+		t.Fatalf("Decrypt(%q): %v", ct, err)
+	}
+	if pt != pan {
+		t.Fatalf("round trip mismatch: got %q, want %q", pt, pan)
+	}
+}
+
+// TestPolicyCipherOddMutableLengthDoesNotPanic covers a pre-existing
+// ff3.Cipher limitation (it panics on odd-length input) reached through a
+// PositionMask whose "#" count is odd, such as a 6-digit BIN plus last-4
+// mask on a 15-digit PAN. PolicyCipher must surface ErrCipherPanic instead
+// of crashing the process.
+func TestPolicyCipherOddMutableLengthDoesNotPanic(t *testing.T) {
+	const pan15 = "411111111111111"
+
+	p := NewPolicyCipher(newPolicyTestCipher(t), PositionMask("111111#####1111"))
+
+	_, err := p.Encrypt(pan15)
+	if !errors.Is(err, ErrCipherPanic) {
+		t.Fatalf("Encrypt(%q) = err %v, want ErrCipherPanic", pan15, err)
+	}
+}
+
+func TestPolicyCipherPositionMaskRoundTrip(t *testing.T) {
+	const pan = "4111111111111111"
+
+	// Hold the 6-digit BIN and last 4 digits fixed; only the middle digits
+	// are re-keyed.
+	pattern := "111111######1111"
+	p := NewPolicyCipher(newPolicyTestCipher(t), PositionMask(pattern))
+
+	ct, err := p.Encrypt(pan)
+	if err != nil {
+		t.Fatalf("Encrypt(%q): %v", pan, err)
+	}
+	if ct[:6] != pan[:6] || ct[len(ct)-4:] != pan[len(pan)-4:] {
+		t.Fatalf("Encrypt(%q) = %q, BIN/last-4 not preserved", pan, ct)
+	}
+
+	pt, err := p.Decrypt(ct)
+	if err != nil {
+		t.Fatalf("Decrypt(%q): %v", ct, err)
+	}
+	if pt != pan {
+		t.Fatalf("round trip mismatch: got %q, want %q", pt, pan)
+	}
+}
+
+func TestPolicyCipherPredicateRoundTrip(t *testing.T) {
+	const ssn = "1234567890"
+
+	// Exclude the reserved "000" and "666" SSN area-number prefixes.
+	notReserved := Predicate(func(output string) bool {
+		return len(output) == 10 && output[:3] != "000" && output[:3] != "666"
+	})
+	p := NewPolicyCipher(newPolicyTestCipher(t), notReserved)
+
+	ct, err := p.Encrypt(ssn)
+	if err != nil {
+		t.Fatalf("Encrypt(%q): %v", ssn, err)
+	}
+	if ct[:3] == "000" || ct[:3] == "666" {
+		t.Fatalf("Encrypt(%q) = %q, in reserved range", ssn, ct)
+	}
+
+	pt, err := p.Decrypt(ct)
+	if err != nil {
+		t.Fatalf("Decrypt(%q): %v", ct, err)
+	}
+	if pt != ssn {
+		t.Fatalf("round trip mismatch: got %q, want %q", pt, ssn)
+	}
+}
+
+// TestPolicyCipherEncryptIsDeterministic confirms cycle-walking is
+// deterministic: the same (key, tweak, input) always walks the same
+// sequence of candidates to the same result.
+func TestPolicyCipherEncryptIsDeterministic(t *testing.T) {
+	const pan = "4111111111111111"
+
+	p1 := NewPolicyCipher(newPolicyTestCipher(t), LuhnPreserving(len(pan)))
+	p2 := NewPolicyCipher(newPolicyTestCipher(t), LuhnPreserving(len(pan)))
+
+	ct1, err := p1.Encrypt(pan)
+	if err != nil {
+		t.Fatalf("Encrypt(%q): %v", pan, err)
+	}
+	ct2, err := p2.Encrypt(pan)
+	if err != nil {
+		t.Fatalf("Encrypt(%q): %v", pan, err)
+	}
+	if ct1 != ct2 {
+		t.Fatalf("cycle-walking not deterministic: got %q and %q for the same input", ct1, ct2)
+	}
+}
+
+// TestPolicyCipherDecryptNonSatisfyingPlaintext documents the limitation
+// called out in DecryptWithTweak's doc comment: cycle-walked decryption
+// only recovers the original plaintext when that plaintext itself already
+// satisfied Policy.Predicate. Here the "plaintext" fed to Decrypt is an
+// arbitrary Luhn-invalid digit string, so the decrypt-side walk diverges
+// from the encrypt-side walk; it must not silently return that input back
+// unchanged as if it had round-tripped.
+func TestPolicyCipherDecryptNonSatisfyingPlaintext(t *testing.T) {
+	const notLuhnValid = "4111111111111112"
+
+	p := NewPolicyCipher(newPolicyTestCipher(t), LuhnPreserving(len(notLuhnValid)))
+
+	pt, err := p.Decrypt(notLuhnValid)
+	if err == nil && pt == notLuhnValid {
+		t.Fatalf("Decrypt(%q) returned its non-satisfying input unchanged; expected it to walk to a different candidate or hit ErrCycleLimitExceeded", notLuhnValid)
+	}
+}